@@ -12,6 +12,10 @@ const (
 	ErrNoStore = -1 - iota
 	ErrNotFound
 	ErrIO
+	ErrBufferTooSmall
+	ErrNoIter
+	ErrIterDone
+	ErrNotInstrumented
 )
 
 // Design:
@@ -26,21 +30,55 @@ const (
 // NOTE: This is totally a premature optimization and we may need to ditch all of it. I got a bit
 // carried away.
 
+// handleIndexBits is the number of low bits of a handle dedicated to the registry index; the
+// remaining high bits (32-handleIndexBits of them) are a generation counter, which wraps and
+// risks an ABA reuse once a slot has been registered and unregistered 2^(32-handleIndexBits)
+// times. 2^8 live slots is still far more than we ever expect to need (one slot per blockstore a
+// Rust-side FVM instance holds a handle to) while leaving 24 generation bits — a wraparound would
+// need over 16 million Register/Unregister cycles on the very same slot index.
+const handleIndexBits = 8
+const handleIndexMask = 1<<handleIndexBits - 1
+
+// genBits/genMask bound the generation counter to exactly the bits packHandle actually embeds in
+// the returned int32; gen must be masked to this range everywhere it's stored or compared,
+// otherwise a generation that grew past it would be truncated on its way into the handle but not
+// in slot.gen, permanently desyncing the two.
+const genBits = 32 - handleIndexBits
+const genMask = 1<<genBits - 1
+
+func packHandle(idx int, gen uint32) int32 {
+	return int32(uint32(idx)&handleIndexMask | gen<<handleIndexBits)
+}
+
+func unpackHandle(handle int32) (idx int, gen uint32) {
+	u := uint32(handle)
+	return int(u & handleIndexMask), u >> handleIndexBits
+}
+
+// slot is a single registry entry. Once published, a slot is never mutated in place; Register and
+// Unregister instead swap in a brand new *slot, so a concurrent Lookup either sees the old slot or
+// the new one, never a half-written one.
+type slot struct {
+	bs  blockstore.Blockstore
+	gen uint32
+}
+
 var (
 	// "snapshot" of the registered blockstores for atomic access.
 	// Well, sort of a snapshot. The backing slice is quite mutable, however:
 	// 1. The slice _header_ isn't mutable.
-	// 2. Only _free_ slots in the backing slice will be modified.
-	atomicRegistry unsafe.Pointer // *[]blockstore.Blockstore
+	// 2. Each element is itself an atomically-swapped *slot, so growing the registry never
+	//    invalidates a snapshot held by a concurrent Lookup.
+	atomicRegistry unsafe.Pointer // *[]unsafe.Pointer, each element a *slot
 
 	mu       sync.Mutex
-	registry []blockstore.Blockstore // slice of registered blockstores.
-	freelist []int                   // a "linked list" of free slots in the registry.
-	lastIdx  int                     // the "head" of the freelist.
+	registry []unsafe.Pointer // slice of *slot, indexed by handle index.
+	freelist []int            // a "linked list" of free slots in the registry.
+	lastIdx  int              // the "head" of the freelist.
 )
 
 func init() {
-	atomic.StorePointer(&atomicRegistry, unsafe.Pointer(new([]blockstore.Blockstore)))
+	atomic.StorePointer(&atomicRegistry, unsafe.Pointer(new([]unsafe.Pointer)))
 }
 
 // Register a new blockstore and get a handle.
@@ -49,47 +87,76 @@ func Register(bs blockstore.Blockstore) int32 {
 	defer mu.Unlock()
 
 	idx := lastIdx
+	grew := false
 	if lastIdx >= len(registry) {
-		// We don't need atomics here because we're writing to a "new" section of the registry.
-		registry = append(registry, bs)
+		registry = append(registry, nil)
 		freelist = append(freelist, len(freelist))
 		lastIdx = len(freelist)
 		idx = lastIdx - 1
+		grew = true
 	} else {
-		// We don't need an atomic here because the index is free.
 		lastIdx = freelist[idx]
-		registry[idx] = bs
 	}
 
-	registry := registry // explicitly copy so we get a new slice header.
-	atomic.StorePointer(&atomicRegistry, unsafe.Pointer(&registry))
-	return int32(idx)
+	gen := uint32(1)
+	if old := (*slot)(registry[idx]); old != nil {
+		gen = (old.gen + 1) & genMask
+	}
+	atomic.StorePointer(&registry[idx], unsafe.Pointer(&slot{bs: bs, gen: gen}))
+
+	if grew {
+		// The backing array itself was reallocated, so readers holding an older snapshot need a
+		// new one; readers holding the current snapshot already observe registry[idx] directly.
+		registry := registry // explicitly copy so we get a new slice header.
+		atomic.StorePointer(&atomicRegistry, unsafe.Pointer(&registry))
+	}
+
+	return packHandle(idx, gen)
 }
 
 // Unregister a blockstore.
 //
-// WARNING: This method must be called at most _once_ with a handle previously returned by Register.
-// Furthermore, it must not be called concurretnly with a Lookup of the same handle.
+// Unregister is safe to call concurrently with a Lookup of the same handle: Lookup will either
+// see the blockstore or observe that the handle's generation no longer matches and return nil.
+// A second Unregister of an already-reclaimed handle is a harmless no-op.
 func Unregister(handle int32) {
 	mu.Lock()
 	defer mu.Unlock()
 
-	freelist[handle] = lastIdx
-	lastIdx = int(handle)
-	registry[handle] = nil
+	idx, gen := unpackHandle(handle)
+	if idx >= len(registry) {
+		return
+	}
+	if cur := (*slot)(registry[idx]); cur == nil || cur.gen != gen {
+		return
+	}
+
+	// Bump the generation, rather than keeping it, so that a second Unregister of this same handle
+	// fails the cur.gen != gen check above and returns early instead of re-freeing idx (which would
+	// corrupt the freelist into a self-loop, permanently pinning every subsequent Register to idx).
+	// This also covers a concurrent or late Lookup with the stale handle, which now reads a nil
+	// blockstore for the same reason a reused slot would.
+	atomic.StorePointer(&registry[idx], unsafe.Pointer(&slot{gen: (gen + 1) & genMask}))
+
+	freelist[idx] = lastIdx
+	lastIdx = idx
 }
 
-// Lookup a blockstore by handle.
-//
-// WARNING: This method must be called witha valid handle to avoid undefined behavior. It must be
-// called between Register and Unregister, and must not be called concurrently or after
-// Unregistering the blockstore.
+// Lookup a blockstore by handle. Lookup is lock-free: it performs one atomic load of the registry
+// snapshot and one atomic load of the target slot. It returns nil if the handle is invalid, was
+// never registered, or has since been unregistered (including a handle whose slot was reused by a
+// later Register call).
 func Lookup(handle int32) blockstore.Blockstore {
-	registry := *(*[]blockstore.Blockstore)(atomic.LoadPointer(&atomicRegistry))
+	idx, gen := unpackHandle(handle)
 
-	if int(handle) >= len(registry) {
+	registry := *(*[]unsafe.Pointer)(atomic.LoadPointer(&atomicRegistry))
+	if idx >= len(registry) {
 		return nil
 	}
 
-	return registry[handle]
+	s := (*slot)(atomic.LoadPointer(&registry[idx]))
+	if s == nil || s.gen != gen {
+		return nil
+	}
+	return s.bs
 }
@@ -0,0 +1,58 @@
+package blockstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+)
+
+// fakeBlockstore is a minimal blockstore.Blockstore used to exercise the registry in isolation,
+// without needing a real backing store.
+type fakeBlockstore struct{}
+
+func (fakeBlockstore) View(context.Context, cid.Cid, func([]byte) error) error { panic("unused") }
+func (fakeBlockstore) Get(context.Context, cid.Cid) (blocks.Block, error)       { panic("unused") }
+func (fakeBlockstore) GetSize(context.Context, cid.Cid) (int, error)           { panic("unused") }
+func (fakeBlockstore) Put(context.Context, blocks.Block) error                 { panic("unused") }
+func (fakeBlockstore) PutMany(context.Context, []blocks.Block) error           { panic("unused") }
+func (fakeBlockstore) Has(context.Context, cid.Cid) (bool, error)              { panic("unused") }
+func (fakeBlockstore) DeleteBlock(context.Context, cid.Cid) error              { panic("unused") }
+func (fakeBlockstore) AllKeysChan(context.Context) (<-chan cid.Cid, error)     { panic("unused") }
+func (fakeBlockstore) HashOnRead(bool)                                        {}
+
+var _ blockstore.Blockstore = fakeBlockstore{}
+
+func TestRegisterLookupUnregister(t *testing.T) {
+	bs := fakeBlockstore{}
+	handle := Register(bs)
+
+	if got := Lookup(handle); got != bs {
+		t.Fatalf("Lookup returned %v, want the registered blockstore", got)
+	}
+
+	Unregister(handle)
+	if got := Lookup(handle); got != nil {
+		t.Fatalf("Lookup after Unregister returned %v, want nil", got)
+	}
+}
+
+// TestUnregisterIsIdempotent reproduces a Rust-side double-free: Unregister called twice on the
+// same handle must not corrupt the freelist (which would otherwise pin every later Register to the
+// same slot, silently evicting unrelated live handles).
+func TestUnregisterIsIdempotent(t *testing.T) {
+	first := Register(fakeBlockstore{})
+	Unregister(first)
+	Unregister(first) // the double-free: must be a harmless no-op.
+
+	second := Register(fakeBlockstore{})
+	third := Register(fakeBlockstore{})
+	if second == third {
+		t.Fatalf("Register handed out the same handle twice: %d", second)
+	}
+	if Lookup(second) == nil {
+		t.Fatalf("second handle %d was evicted by a later Register", second)
+	}
+}
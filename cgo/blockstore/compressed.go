@@ -0,0 +1,230 @@
+package blockstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/DataDog/zstd"
+	"github.com/filecoin-project/lotus/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+)
+
+// payloadMagic is prefixed to every payload Compressed writes, ahead of the payloadKind byte. A
+// single kind byte isn't enough on its own to tell a wrapped payload apart from a block written
+// before this wrapper existed (or by some other store entirely): payloadKind's own values (0, 1,
+// 2) are common leading bytes of raw CBOR and other unwrapped data. The two-byte magic is chosen
+// to make a legacy block colliding with it vanishingly unlikely.
+var payloadMagic = [2]byte{0xf9, 0x3c}
+
+// payloadKind is a one-byte header, following payloadMagic, identifying how the rest of the bytes
+// are compressed.
+type payloadKind byte
+
+const (
+	payloadRaw payloadKind = iota
+	payloadZstd
+	payloadZstdDict
+)
+
+// CompressedOptions configures a Compressed blockstore.
+type CompressedOptions struct {
+	// Level is the zstd compression level used for blocks written without a dictionary.
+	Level int
+	// Dict is an optional zstd dictionary used to improve the compression ratio on the many small
+	// (<4KiB) blocks that dominate Filecoin state, where there's too little data in any one block
+	// for zstd to build up its own useful compression context. No dictionary ships with this
+	// package: there's no representative corpus of real chain data to train one against here, and
+	// a dictionary trained on the wrong block shapes can easily make compression worse, not
+	// better. Callers who want this should train their own with `zstd --train` against a sample of
+	// their own block data and pass the result here. If empty, every block is compressed without a
+	// dictionary.
+	Dict []byte
+	// DictID is stored alongside payloadZstdDict blocks so a Compressed instance configured with a
+	// different (or no) dictionary can tell at read time whether it holds the matching one.
+	DictID byte
+}
+
+// Compressed wraps a blockstore.Blockstore, transparently zstd-compressing payloads on Put and
+// decompressing them on View/Get. It is registered with Register like any other blockstore, so
+// compression happens entirely below the cgo boundary: the FVM sees no change in API, just a
+// smaller backing store.
+type Compressed struct {
+	inner blockstore.Blockstore
+	opts  CompressedOptions
+
+	// hashOnRead records whether View/Get should verify the decoded plaintext against its CID.
+	// This can't be delegated to inner.HashOnRead: inner only ever sees encoded (and usually
+	// compressed) bytes, which never hash to the block's CID even when nothing is wrong, so
+	// forwarding would make every read fail once enabled.
+	hashOnRead uint32
+}
+
+// NewCompressed wraps inner with transparent zstd compression.
+func NewCompressed(inner blockstore.Blockstore, opts CompressedOptions) *Compressed {
+	return &Compressed{inner: inner, opts: opts}
+}
+
+func (c *Compressed) encode(data []byte) ([]byte, error) {
+	if len(c.opts.Dict) == 0 {
+		compressed, err := zstd.CompressLevel(nil, data, c.opts.Level)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, 0, len(payloadMagic)+1+len(compressed))
+		out = append(out, payloadMagic[:]...)
+		out = append(out, byte(payloadZstd))
+		return append(out, compressed...), nil
+	}
+
+	compressed, err := zstd.NewCtx().CompressDict(nil, data, c.opts.Dict)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(payloadMagic)+2+len(compressed))
+	out = append(out, payloadMagic[:]...)
+	out = append(out, byte(payloadZstdDict), c.opts.DictID)
+	return append(out, compressed...), nil
+}
+
+// decode reverses encode. A payload that doesn't start with payloadMagic was never wrapped by a
+// Compressed at all — most commonly a legacy block written before this wrapper existed, but also
+// possibly one written by a store with compression disabled entirely — so it's returned as-is
+// rather than rejected.
+func (c *Compressed) decode(data []byte) ([]byte, error) {
+	if len(data) < len(payloadMagic) || !bytes.Equal(data[:len(payloadMagic)], payloadMagic[:]) {
+		return data, nil
+	}
+	data = data[len(payloadMagic):]
+
+	if len(data) == 0 {
+		return nil, fmt.Errorf("compressed blockstore: truncated payload header")
+	}
+
+	switch payloadKind(data[0]) {
+	case payloadRaw:
+		return data[1:], nil
+	case payloadZstd:
+		return zstd.Decompress(nil, data[1:])
+	case payloadZstdDict:
+		if len(data) < 2 {
+			return nil, fmt.Errorf("compressed blockstore: truncated zstd+dict header")
+		}
+		if data[1] != c.opts.DictID || len(c.opts.Dict) == 0 {
+			return nil, fmt.Errorf("compressed blockstore: block was written with dict id %d, which this instance doesn't have", data[1])
+		}
+		return zstd.NewCtx().DecompressDict(nil, data[2:], c.opts.Dict)
+	default:
+		return nil, fmt.Errorf("compressed blockstore: unknown payload kind %d", data[0])
+	}
+}
+
+// verifyHash re-derives k's hash from the decoded plaintext raw and reports a mismatch, honoring
+// the same contract inner.HashOnRead(true) would if raw were stored (and hashed) directly.
+func verifyHash(k cid.Cid, raw []byte) error {
+	recomputed, err := k.Prefix().Sum(raw)
+	if err != nil {
+		return err
+	}
+	if !recomputed.Equals(k) {
+		return fmt.Errorf("compressed blockstore: hash mismatch for %s", k)
+	}
+	return nil
+}
+
+func (c *Compressed) View(ctx context.Context, k cid.Cid, fn func([]byte) error) error {
+	return c.inner.View(ctx, k, func(data []byte) error {
+		raw, err := c.decode(data)
+		if err != nil {
+			return err
+		}
+		if atomic.LoadUint32(&c.hashOnRead) != 0 {
+			if err := verifyHash(k, raw); err != nil {
+				return err
+			}
+		}
+		return fn(raw)
+	})
+}
+
+func (c *Compressed) Get(ctx context.Context, k cid.Cid) (blocks.Block, error) {
+	b, err := c.inner.Get(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := c.decode(b.RawData())
+	if err != nil {
+		return nil, err
+	}
+	if atomic.LoadUint32(&c.hashOnRead) != 0 {
+		if err := verifyHash(k, raw); err != nil {
+			return nil, err
+		}
+	}
+	return blocks.NewBlockWithCid(raw, k)
+}
+
+// GetSize returns the size of the decompressed block. Unlike the other methods here, this isn't
+// free: there's no cheap way to recover the original size without decompressing, so this pays the
+// same cost as a full Get.
+func (c *Compressed) GetSize(ctx context.Context, k cid.Cid) (int, error) {
+	b, err := c.Get(ctx, k)
+	if err != nil {
+		return 0, err
+	}
+	return len(b.RawData()), nil
+}
+
+func (c *Compressed) Put(ctx context.Context, b blocks.Block) error {
+	encoded, err := c.encode(b.RawData())
+	if err != nil {
+		return err
+	}
+	wrapped, err := blocks.NewBlockWithCid(encoded, b.Cid())
+	if err != nil {
+		return err
+	}
+	return c.inner.Put(ctx, wrapped)
+}
+
+func (c *Compressed) PutMany(ctx context.Context, bs []blocks.Block) error {
+	wrapped := make([]blocks.Block, len(bs))
+	for i, b := range bs {
+		encoded, err := c.encode(b.RawData())
+		if err != nil {
+			return err
+		}
+		w, err := blocks.NewBlockWithCid(encoded, b.Cid())
+		if err != nil {
+			return err
+		}
+		wrapped[i] = w
+	}
+	return c.inner.PutMany(ctx, wrapped)
+}
+
+func (c *Compressed) Has(ctx context.Context, k cid.Cid) (bool, error) {
+	return c.inner.Has(ctx, k)
+}
+
+func (c *Compressed) DeleteBlock(ctx context.Context, k cid.Cid) error {
+	return c.inner.DeleteBlock(ctx, k)
+}
+
+func (c *Compressed) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	return c.inner.AllKeysChan(ctx)
+}
+
+// HashOnRead enables or disables verifying a block's decoded plaintext against its CID on every
+// View/Get. Unlike a plain blockstore, this can't be delegated to inner: inner only ever stores
+// (and would hash) the still-compressed bytes, which never match the plaintext's CID, so the
+// verification has to happen here, after decode.
+func (c *Compressed) HashOnRead(enabled bool) {
+	var v uint32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreUint32(&c.hashOnRead, v)
+}
@@ -1,9 +1,10 @@
 package blockstore
 
 import (
-	"context"
+	"encoding/binary"
 	"unsafe"
 
+	"github.com/filecoin-project/fvm/cgo/token"
 	"github.com/filecoin-project/lotus/blockstore"
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
@@ -12,21 +13,71 @@ import (
 /*
 #include <stdint.h>
 typedef const uint8_t* buf_t;
+typedef uint8_t* mut_buf_t;
 */
 import "C"
 
+// Per-entry status codes used by the batched put_many/get_many/has_many entry points below. A
+// single call touches many blocks, so failures are reported per-entry rather than aborting the
+// whole batch.
+const (
+	statusOK uint8 = iota
+	statusNotFound
+	statusIO
+)
+
 func toCid(k C.buf_t, k_len C.int32_t) cid.Cid {
 	return *(*cid.Cid)(unsafe.Pointer(&struct{ str string }{str: C.GoStringN((*C.char)(unsafe.Pointer(k)), C.int(k_len))}))
 }
 
+func cidFromBytes(b []byte) cid.Cid {
+	return *(*cid.Cid)(unsafe.Pointer(&struct{ str string }{str: string(b)}))
+}
+
+// readUvarint reads a varint-prefixed length from buf and returns the value along with the
+// remaining, unconsumed bytes.
+func readUvarint(buf []byte) (uint64, []byte, bool) {
+	v, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return 0, nil, false
+	}
+	return v, buf[n:], true
+}
+
+// readLenPrefixed reads a varint length followed by that many bytes, returning the slice and the
+// remaining, unconsumed bytes.
+func readLenPrefixed(buf []byte) ([]byte, []byte, bool) {
+	n, rest, ok := readUvarint(buf)
+	if !ok || uint64(len(rest)) < n {
+		return nil, nil, false
+	}
+	return rest[:n], rest[n:], true
+}
+
+// cgo_new_cancel_token creates a new cancellation token. Pass the returned handle as the cancel
+// argument to any of the calls below, and call cgo_cancel with the same handle to abort every
+// in-flight call sharing it (e.g. when the message execution they were made on behalf of is
+// aborted for gas exhaustion or a deadline). Pass 0 for cancel to opt out and get a plain
+// context.Background().
+//
+//export cgo_new_cancel_token
+func cgo_new_cancel_token() C.int32_t {
+	return C.int32_t(token.New())
+}
+
+//export cgo_cancel
+func cgo_cancel(cancel C.int32_t) {
+	token.Cancel(int32(cancel))
+}
+
 //export cgobs_get
-func cgobs_get(store C.int32_t, k C.buf_t, k_len C.int32_t, block **C.uint8_t, size *C.int32_t) C.int32_t {
+func cgobs_get(store C.int32_t, cancel C.int32_t, k C.buf_t, k_len C.int32_t, block **C.uint8_t, size *C.int32_t) C.int32_t {
 	c := toCid(k, k_len)
 	bs := Lookup(int32(store))
 	if bs == nil {
 		return ErrNoStore
 	}
-	err := bs.View(context.Background(), c, func(data []byte) error {
+	err := bs.View(token.Context(int32(cancel)), c, func(data []byte) error {
 		*block = (C.buf_t)(C.CBytes(data))
 		*size = C.int32_t(len(data))
 		return nil
@@ -42,44 +93,249 @@ func cgobs_get(store C.int32_t, k C.buf_t, k_len C.int32_t, block **C.uint8_t, s
 	}
 }
 
+// cgobs_get_into reads a block into a caller-allocated buffer, avoiding the malloc/free round
+// trip that cgobs_get pays for on every call via C.CBytes. out_buf must point to a buffer of at
+// least out_cap bytes; if the block doesn't fit, ErrBufferTooSmall is returned with the required
+// size written to out_size so the caller can resize and retry.
+//
+//export cgobs_get_into
+func cgobs_get_into(store C.int32_t, cancel C.int32_t, k C.buf_t, k_len C.int32_t, out_buf C.mut_buf_t, out_cap C.int32_t, out_size *C.int32_t) C.int32_t {
+	c := toCid(k, k_len)
+	bs := Lookup(int32(store))
+	if bs == nil {
+		return ErrNoStore
+	}
+
+	var tooSmall bool
+	err := bs.View(token.Context(int32(cancel)), c, func(data []byte) error {
+		*out_size = C.int32_t(len(data))
+		if len(data) > int(out_cap) {
+			tooSmall = true
+			return nil
+		}
+		dst := unsafe.Slice((*byte)(out_buf), out_cap)
+		copy(dst, data)
+		return nil
+	})
+
+	switch {
+	case err == blockstore.ErrNotFound:
+		return ErrNotFound
+	case err != nil:
+		return ErrIO
+	case tooSmall:
+		return ErrBufferTooSmall
+	default:
+		return 0
+	}
+}
+
 //export cgobs_put
-func cgobs_put(store C.int32_t, k C.buf_t, k_len C.int32_t, block C.buf_t, block_len C.int32_t) C.int32_t {
+func cgobs_put(store C.int32_t, cancel C.int32_t, k C.buf_t, k_len C.int32_t, block C.buf_t, block_len C.int32_t) C.int32_t {
 	c := toCid(k, k_len)
 	bs := Lookup(int32(store))
 	if bs == nil {
 		return ErrNoStore
 	}
 	b, _ := blocks.NewBlockWithCid(C.GoBytes(unsafe.Pointer(block), C.int(block_len)), c)
-	if bs.Put(context.Background(), b) != nil {
+	if bs.Put(token.Context(int32(cancel)), b) != nil {
 		return ErrIO
 	}
 	return 0
 }
 
-// TODO: Implement a "put many". We should just pass a single massive buffer, or an array of
-// buffers?
+// cgobs_put_many writes a batch of blocks packed into a single buffer as a varint entry count
+// followed by that many {cid_len, cid_bytes, data_len, data_bytes} varint-prefixed records. This
+// lets callers amortize the FFI crossing over many small blocks instead of paying for it once per
+// block.
+//
+// statuses must point to a caller-allocated array of at least as many entries as were packed into
+// buf; on return, statuses[i] holds a status byte (0 ok, 2 io) for the i'th record.
+//
+//export cgobs_put_many
+func cgobs_put_many(store C.int32_t, cancel C.int32_t, buf C.buf_t, buf_len C.int32_t, statuses *C.uint8_t) C.int32_t {
+	bs := Lookup(int32(store))
+	if bs == nil {
+		return ErrNoStore
+	}
+	ctx := token.Context(int32(cancel))
+
+	data := C.GoBytes(unsafe.Pointer(buf), C.int(buf_len))
+
+	count, rest, ok := readUvarint(data)
+	if !ok {
+		return ErrIO
+	}
+	// Each packed record needs at least 2 bytes (a cid-length varint and a data-length varint,
+	// even when both lengths are zero), so a count implying more records than that is corrupt
+	// input. Reject it here rather than letting a bogus, oversized count panic the process via
+	// make/unsafe.Slice below.
+	if count > uint64(len(rest))/2 {
+		return ErrIO
+	}
+
+	out := unsafe.Slice(statuses, count)
+	blks := make([]blocks.Block, count)
+	for i := uint64(0); i < count; i++ {
+		var cidBytes, dataBytes []byte
+
+		cidBytes, rest, ok = readLenPrefixed(rest)
+		if !ok {
+			return ErrIO
+		}
+		dataBytes, rest, ok = readLenPrefixed(rest)
+		if !ok {
+			return ErrIO
+		}
+
+		b, _ := blocks.NewBlockWithCid(dataBytes, cidFromBytes(cidBytes))
+		blks[i] = b
+	}
+
+	// The batch is written as a unit, so on failure we can't tell which entry (if any) was the
+	// culprit; report the whole batch as failed. lotus's blockstore.Blockstore always implements
+	// PutMany, so there's no fallback path to a per-block Put loop to fall back to here.
+	status := statusOK
+	if err := bs.PutMany(ctx, blks); err != nil {
+		status = statusIO
+	}
+	for i := range out {
+		out[i] = C.uint8_t(status)
+	}
+	return 0
+}
+
+// cgobs_get_many reads a batch of blocks whose CIDs are packed into buf as a varint entry count
+// followed by that many varint-prefixed CIDs.
+//
+// out_blocks, out_sizes, and statuses must each point to caller-allocated arrays of at least count
+// entries. On return, out_blocks[i]/out_sizes[i] hold a block (owned by the caller, to be freed as
+// with cgobs_get) and its size whenever statuses[i] is 0; a non-zero status (1 notfound, 2 io)
+// leaves that slot untouched.
+//
+//export cgobs_get_many
+func cgobs_get_many(store C.int32_t, cancel C.int32_t, keys C.buf_t, keys_len C.int32_t, count C.int32_t, out_blocks *C.buf_t, out_sizes *C.int32_t, statuses *C.uint8_t) C.int32_t {
+	bs := Lookup(int32(store))
+	if bs == nil {
+		return ErrNoStore
+	}
+	ctx := token.Context(int32(cancel))
+
+	data := C.GoBytes(unsafe.Pointer(keys), C.int(keys_len))
+
+	n, rest, ok := readUvarint(data)
+	if !ok || n != uint64(count) {
+		return ErrIO
+	}
+	// Each packed CID needs at least 1 byte (its length varint, even when zero-length), so a count
+	// implying more entries than that is corrupt input. Reject it here rather than slicing the
+	// caller-provided output arrays with a bogus, oversized n below.
+	if n > uint64(len(rest)) {
+		return ErrIO
+	}
+
+	blocksOut := unsafe.Slice(out_blocks, n)
+	sizesOut := unsafe.Slice(out_sizes, n)
+	statusesOut := unsafe.Slice(statuses, n)
+
+	for i := uint64(0); i < n; i++ {
+		var cidBytes []byte
+		cidBytes, rest, ok = readLenPrefixed(rest)
+		if !ok {
+			return ErrIO
+		}
+
+		err := bs.View(ctx, cidFromBytes(cidBytes), func(d []byte) error {
+			blocksOut[i] = (C.buf_t)(C.CBytes(d))
+			sizesOut[i] = C.int32_t(len(d))
+			return nil
+		})
+
+		switch err {
+		case nil:
+			statusesOut[i] = C.uint8_t(statusOK)
+		case blockstore.ErrNotFound:
+			statusesOut[i] = C.uint8_t(statusNotFound)
+		default:
+			statusesOut[i] = C.uint8_t(statusIO)
+		}
+	}
+	return 0
+}
+
+// cgobs_has_many checks for the presence of a batch of CIDs packed into buf the same way as
+// cgobs_get_many's keys argument.
+//
+// statuses must point to a caller-allocated array of at least count entries; on return,
+// statuses[i] is 0 if the block is present, 1 if absent, or 2 on an I/O error.
+//
+//export cgobs_has_many
+func cgobs_has_many(store C.int32_t, cancel C.int32_t, keys C.buf_t, keys_len C.int32_t, count C.int32_t, statuses *C.uint8_t) C.int32_t {
+	bs := Lookup(int32(store))
+	if bs == nil {
+		return ErrNoStore
+	}
+	ctx := token.Context(int32(cancel))
+
+	data := C.GoBytes(unsafe.Pointer(keys), C.int(keys_len))
+
+	n, rest, ok := readUvarint(data)
+	if !ok || n != uint64(count) {
+		return ErrIO
+	}
+	// Each packed CID needs at least 1 byte (its length varint, even when zero-length), so a count
+	// implying more entries than that is corrupt input. Reject it here rather than slicing the
+	// caller-provided output array with a bogus, oversized n below.
+	if n > uint64(len(rest)) {
+		return ErrIO
+	}
+
+	statusesOut := unsafe.Slice(statuses, n)
+	for i := uint64(0); i < n; i++ {
+		var cidBytes []byte
+		cidBytes, rest, ok = readLenPrefixed(rest)
+		if !ok {
+			return ErrIO
+		}
+
+		has, err := bs.Has(ctx, cidFromBytes(cidBytes))
+		switch err {
+		case nil:
+			if has {
+				statusesOut[i] = C.uint8_t(statusOK)
+			} else {
+				statusesOut[i] = C.uint8_t(statusNotFound)
+			}
+		case blockstore.ErrNotFound:
+			statusesOut[i] = C.uint8_t(statusNotFound)
+		default:
+			statusesOut[i] = C.uint8_t(statusIO)
+		}
+	}
+	return 0
+}
 
 //export cgobs_delete
-func cgobs_delete(store C.int32_t, k C.buf_t, k_len C.int32_t) C.int32_t {
+func cgobs_delete(store C.int32_t, cancel C.int32_t, k C.buf_t, k_len C.int32_t) C.int32_t {
 	c := toCid(k, k_len)
 	bs := Lookup(int32(store))
 	if bs == nil {
 		return ErrNoStore
 	}
-	if bs.DeleteBlock(context.Background(), c) != nil {
+	if bs.DeleteBlock(token.Context(int32(cancel)), c) != nil {
 		return ErrIO
 	}
 	return 0
 }
 
 //export cgobs_has
-func cgobs_has(store C.int32_t, k C.buf_t, k_len C.int32_t) C.int32_t {
+func cgobs_has(store C.int32_t, cancel C.int32_t, k C.buf_t, k_len C.int32_t) C.int32_t {
 	c := toCid(k, k_len)
 	bs := Lookup(int32(store))
 	if bs == nil {
 		return ErrNoStore
 	}
-	has, err := bs.Has(context.Background(), c)
+	has, err := bs.Has(token.Context(int32(cancel)), c)
 	switch err {
 	case nil:
 	case blockstore.ErrNotFound:
@@ -0,0 +1,58 @@
+package blockstore
+
+import (
+	"encoding/binary"
+	"testing"
+	"unsafe"
+)
+
+/*
+#include <stdint.h>
+typedef const uint8_t* buf_t;
+*/
+import "C"
+
+// TestPutManyRejectsOversizedCount guards against a malformed buffer whose leading varint claims
+// far more records than the buffer could possibly hold: it must return ErrIO rather than panicking
+// the process via make([]blocks.Block, count) on a bogus, huge count.
+func TestPutManyRejectsOversizedCount(t *testing.T) {
+	store := Register(fakeBlockstore{})
+	defer Unregister(store)
+
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, ^uint64(0)) // math.MaxUint64 records, no record bytes follow.
+	buf = buf[:n]
+
+	statuses := make([]uint8, 1)
+	status := cgobs_put_many(
+		C.int32_t(store), 0,
+		(C.buf_t)(unsafe.Pointer(&buf[0])), C.int32_t(len(buf)),
+		(*C.uint8_t)(unsafe.Pointer(&statuses[0])),
+	)
+	if status != ErrIO {
+		t.Fatalf("status = %d, want ErrIO (%d)", status, ErrIO)
+	}
+}
+
+// TestGetManyRejectsOversizedCount is the get_many analogue of the above: count is also checked
+// against the remaining keys buffer before the caller-provided output arrays are sliced with it.
+func TestGetManyRejectsOversizedCount(t *testing.T) {
+	store := Register(fakeBlockstore{})
+	defer Unregister(store)
+
+	buf := make([]byte, binary.MaxVarintLen64)
+	hugeN := binary.PutUvarint(buf, ^uint64(0))
+	buf = buf[:hugeN]
+
+	var outBlock C.buf_t
+	var outSize C.int32_t
+	var status C.uint8_t
+	ret := cgobs_get_many(
+		C.int32_t(store), 0,
+		(C.buf_t)(unsafe.Pointer(&buf[0])), C.int32_t(len(buf)), C.int32_t(-1),
+		&outBlock, &outSize, &status,
+	)
+	if ret != ErrIO {
+		t.Fatalf("status = %d, want ErrIO (%d)", ret, ErrIO)
+	}
+}
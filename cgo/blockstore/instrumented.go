@@ -0,0 +1,190 @@
+package blockstore
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/filecoin-project/fvm/cgo/histogram"
+	"github.com/filecoin-project/lotus/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentedOptions configures an Instrumented blockstore.
+type InstrumentedOptions struct {
+	// Tracer, if non-nil, causes every Get/View/Put/Has call to be wrapped in an OpenTelemetry
+	// span tagged with the block's CID. The block's size is not recorded on the span.
+	Tracer trace.Tracer
+}
+
+// Stats holds the counters recorded by an Instrumented blockstore, as of the moment StatsOf was
+// called.
+type Stats struct {
+	Gets, Puts, Hits, Misses uint64
+	BytesIn, BytesOut        uint64
+
+	GetLatencyP50, GetLatencyP99 time.Duration
+	PutLatencyP50, PutLatencyP99 time.Duration
+}
+
+// instrumentedBlockstore wraps a blockstore.Blockstore, recording per-handle counters (and
+// optionally emitting OpenTelemetry spans) around every call so the Rust side can pull them via
+// cgobs_stats for gas/debug accounting at end-of-message.
+type instrumentedBlockstore struct {
+	inner blockstore.Blockstore
+	opts  InstrumentedOptions
+
+	gets, puts, hits, misses uint64
+	bytesIn, bytesOut        uint64
+	getLatency, putLatency   histogram.Latency
+}
+
+// Instrumented wraps inner so that Gets/Puts/Hits/Misses/bytes transferred/latency are recorded
+// and retrievable via StatsOf (or cgobs_stats, once registered with Register).
+func Instrumented(inner blockstore.Blockstore, opts InstrumentedOptions) blockstore.Blockstore {
+	return &instrumentedBlockstore{inner: inner, opts: opts}
+}
+
+func (i *instrumentedBlockstore) span(ctx context.Context, name string, c cid.Cid) (context.Context, trace.Span) {
+	if i.opts.Tracer == nil {
+		return ctx, nil
+	}
+	return i.opts.Tracer.Start(ctx, name, trace.WithAttributes(attribute.String("cid", c.String())))
+}
+
+func endSpan(span trace.Span) {
+	if span != nil {
+		span.End()
+	}
+}
+
+func (i *instrumentedBlockstore) View(ctx context.Context, c cid.Cid, fn func([]byte) error) error {
+	ctx, span := i.span(ctx, "blockstore.View", c)
+	defer endSpan(span)
+
+	start := time.Now()
+	err := i.inner.View(ctx, c, func(data []byte) error {
+		atomic.AddUint64(&i.bytesOut, uint64(len(data)))
+		return fn(data)
+	})
+	i.recordGet(start, err)
+	return err
+}
+
+func (i *instrumentedBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	ctx, span := i.span(ctx, "blockstore.Get", c)
+	defer endSpan(span)
+
+	start := time.Now()
+	b, err := i.inner.Get(ctx, c)
+	if err == nil {
+		atomic.AddUint64(&i.bytesOut, uint64(len(b.RawData())))
+	}
+	i.recordGet(start, err)
+	return b, err
+}
+
+func (i *instrumentedBlockstore) recordGet(start time.Time, err error) {
+	i.getLatency.Record(time.Since(start))
+	atomic.AddUint64(&i.gets, 1)
+	switch err {
+	case nil:
+		atomic.AddUint64(&i.hits, 1)
+	case blockstore.ErrNotFound:
+		atomic.AddUint64(&i.misses, 1)
+	}
+}
+
+// recordHas is recordGet's Has-aware counterpart. Unlike Get/View, a Has call that successfully
+// finds the block absent reports that via its bool result with a nil error, rather than
+// blockstore.ErrNotFound, so recordGet's err-based hit/miss split would count every ordinary miss
+// as a hit.
+func (i *instrumentedBlockstore) recordHas(start time.Time, has bool, err error) {
+	i.getLatency.Record(time.Since(start))
+	atomic.AddUint64(&i.gets, 1)
+	switch {
+	case err != nil && err != blockstore.ErrNotFound:
+		return
+	case has && err == nil:
+		atomic.AddUint64(&i.hits, 1)
+	default:
+		atomic.AddUint64(&i.misses, 1)
+	}
+}
+
+func (i *instrumentedBlockstore) Put(ctx context.Context, b blocks.Block) error {
+	ctx, span := i.span(ctx, "blockstore.Put", b.Cid())
+	defer endSpan(span)
+
+	start := time.Now()
+	err := i.inner.Put(ctx, b)
+	i.putLatency.Record(time.Since(start))
+	atomic.AddUint64(&i.puts, 1)
+	atomic.AddUint64(&i.bytesIn, uint64(len(b.RawData())))
+	return err
+}
+
+func (i *instrumentedBlockstore) PutMany(ctx context.Context, bs []blocks.Block) error {
+	start := time.Now()
+	err := i.inner.PutMany(ctx, bs)
+	i.putLatency.Record(time.Since(start))
+	atomic.AddUint64(&i.puts, uint64(len(bs)))
+	for _, b := range bs {
+		atomic.AddUint64(&i.bytesIn, uint64(len(b.RawData())))
+	}
+	return err
+}
+
+func (i *instrumentedBlockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	ctx, span := i.span(ctx, "blockstore.Has", c)
+	defer endSpan(span)
+
+	start := time.Now()
+	has, err := i.inner.Has(ctx, c)
+	i.recordHas(start, has, err)
+	return has, err
+}
+
+func (i *instrumentedBlockstore) GetSize(ctx context.Context, c cid.Cid) (int, error) {
+	return i.inner.GetSize(ctx, c)
+}
+
+func (i *instrumentedBlockstore) DeleteBlock(ctx context.Context, c cid.Cid) error {
+	return i.inner.DeleteBlock(ctx, c)
+}
+
+func (i *instrumentedBlockstore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	return i.inner.AllKeysChan(ctx)
+}
+
+func (i *instrumentedBlockstore) HashOnRead(enabled bool) {
+	i.inner.HashOnRead(enabled)
+}
+
+func (i *instrumentedBlockstore) stats() Stats {
+	return Stats{
+		Gets:          atomic.LoadUint64(&i.gets),
+		Puts:          atomic.LoadUint64(&i.puts),
+		Hits:          atomic.LoadUint64(&i.hits),
+		Misses:        atomic.LoadUint64(&i.misses),
+		BytesIn:       atomic.LoadUint64(&i.bytesIn),
+		BytesOut:      atomic.LoadUint64(&i.bytesOut),
+		GetLatencyP50: i.getLatency.Percentile(0.50),
+		GetLatencyP99: i.getLatency.Percentile(0.99),
+		PutLatencyP50: i.putLatency.Percentile(0.50),
+		PutLatencyP99: i.putLatency.Percentile(0.99),
+	}
+}
+
+// StatsOf returns the current counters for the blockstore registered under handle, or the zero
+// Stats if handle doesn't refer to an Instrumented blockstore.
+func StatsOf(handle int32) Stats {
+	inst, _ := Lookup(handle).(*instrumentedBlockstore)
+	if inst == nil {
+		return Stats{}
+	}
+	return inst.stats()
+}
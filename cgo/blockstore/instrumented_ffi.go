@@ -0,0 +1,42 @@
+package blockstore
+
+/*
+#include <stdint.h>
+typedef struct {
+	uint64_t gets;
+	uint64_t puts;
+	uint64_t hits;
+	uint64_t misses;
+	uint64_t bytes_in;
+	uint64_t bytes_out;
+	int64_t get_p50_ns;
+	int64_t get_p99_ns;
+	int64_t put_p50_ns;
+	int64_t put_p99_ns;
+} cgobs_stats_t;
+*/
+import "C"
+
+// cgobs_stats fills out with the counters recorded for store, which must have been wrapped with
+// Instrumented before being registered. Returns ErrNotInstrumented if it wasn't.
+//
+//export cgobs_stats
+func cgobs_stats(store C.int32_t, out *C.cgobs_stats_t) C.int32_t {
+	inst, _ := Lookup(int32(store)).(*instrumentedBlockstore)
+	if inst == nil {
+		return ErrNotInstrumented
+	}
+
+	s := inst.stats()
+	out.gets = C.uint64_t(s.Gets)
+	out.puts = C.uint64_t(s.Puts)
+	out.hits = C.uint64_t(s.Hits)
+	out.misses = C.uint64_t(s.Misses)
+	out.bytes_in = C.uint64_t(s.BytesIn)
+	out.bytes_out = C.uint64_t(s.BytesOut)
+	out.get_p50_ns = C.int64_t(s.GetLatencyP50.Nanoseconds())
+	out.get_p99_ns = C.int64_t(s.GetLatencyP99.Nanoseconds())
+	out.put_p50_ns = C.int64_t(s.PutLatencyP50.Nanoseconds())
+	out.put_p99_ns = C.int64_t(s.PutLatencyP99.Nanoseconds())
+	return 0
+}
@@ -0,0 +1,52 @@
+package blockstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+)
+
+// hasOnlyBlockstore answers Has directly with the configured result, panicking on any other
+// method, so TestHasMissIsNotCountedAsHit can't accidentally pass via some other code path.
+type hasOnlyBlockstore struct {
+	fakeBlockstore
+	has bool
+	err error
+}
+
+func (h hasOnlyBlockstore) Has(context.Context, cid.Cid) (bool, error) { return h.has, h.err }
+
+func TestHasMissIsNotCountedAsHit(t *testing.T) {
+	inst := Instrumented(hasOnlyBlockstore{has: false, err: nil}, InstrumentedOptions{}).(*instrumentedBlockstore)
+
+	_, err := inst.Has(context.Background(), cid.Undef)
+	if err != nil {
+		t.Fatalf("Has returned unexpected error: %v", err)
+	}
+
+	stats := inst.stats()
+	if stats.Misses != 1 {
+		t.Fatalf("Misses = %d, want 1", stats.Misses)
+	}
+	if stats.Hits != 0 {
+		t.Fatalf("Hits = %d, want 0", stats.Hits)
+	}
+}
+
+func TestHasHitIsCountedAsHit(t *testing.T) {
+	inst := Instrumented(hasOnlyBlockstore{has: true, err: nil}, InstrumentedOptions{}).(*instrumentedBlockstore)
+
+	_, err := inst.Has(context.Background(), cid.Undef)
+	if err != nil {
+		t.Fatalf("Has returned unexpected error: %v", err)
+	}
+
+	stats := inst.stats()
+	if stats.Hits != 1 {
+		t.Fatalf("Hits = %d, want 1", stats.Hits)
+	}
+	if stats.Misses != 0 {
+		t.Fatalf("Misses = %d, want 0", stats.Misses)
+	}
+}
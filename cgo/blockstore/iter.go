@@ -0,0 +1,185 @@
+package blockstore
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/ipfs/go-cid"
+)
+
+/*
+#include <stdint.h>
+typedef const uint8_t* buf_t;
+typedef uint8_t* mut_buf_t;
+*/
+import "C"
+
+// iterator wraps a single AllKeysChan traversal together with the CancelFunc needed to actually
+// stop the underlying goroutine when the Rust side is done (or gives up) iterating.
+type iterator struct {
+	keys   <-chan cid.Cid
+	cancel context.CancelFunc
+
+	// pending holds a CID already received off keys that didn't fit the caller's buffer on the
+	// last cgobs_iter_next call. Unlike the View behind cgobs_get_into, a channel receive isn't idempotent, so
+	// a too-small buffer can't simply be retried by reading keys again — the CID has to be held
+	// here until a call with enough room for it actually consumes it.
+	pending *cid.Cid
+}
+
+// iterSlot mirrors slot in blockstore.go: iterators get their own handle space (and their own
+// registry), reusing the same generation-tagged, lock-free-on-the-fast-path pattern.
+type iterSlot struct {
+	it  *iterator
+	gen uint32
+}
+
+var (
+	atomicIterRegistry unsafe.Pointer // *[]unsafe.Pointer, each element an *iterSlot
+
+	iterMu       sync.Mutex
+	iterRegistry []unsafe.Pointer
+	iterFreelist []int
+	iterLastIdx  int
+)
+
+func init() {
+	atomic.StorePointer(&atomicIterRegistry, unsafe.Pointer(new([]unsafe.Pointer)))
+}
+
+func registerIter(it *iterator) int32 {
+	iterMu.Lock()
+	defer iterMu.Unlock()
+
+	idx := iterLastIdx
+	grew := false
+	if iterLastIdx >= len(iterRegistry) {
+		iterRegistry = append(iterRegistry, nil)
+		iterFreelist = append(iterFreelist, len(iterFreelist))
+		iterLastIdx = len(iterFreelist)
+		idx = iterLastIdx - 1
+		grew = true
+	} else {
+		iterLastIdx = iterFreelist[idx]
+	}
+
+	gen := uint32(1)
+	if old := (*iterSlot)(iterRegistry[idx]); old != nil {
+		gen = (old.gen + 1) & genMask
+	}
+	atomic.StorePointer(&iterRegistry[idx], unsafe.Pointer(&iterSlot{it: it, gen: gen}))
+
+	if grew {
+		iterRegistry := iterRegistry
+		atomic.StorePointer(&atomicIterRegistry, unsafe.Pointer(&iterRegistry))
+	}
+
+	return packHandle(idx, gen)
+}
+
+func lookupIter(handle int32) *iterator {
+	idx, gen := unpackHandle(handle)
+
+	registry := *(*[]unsafe.Pointer)(atomic.LoadPointer(&atomicIterRegistry))
+	if idx >= len(registry) {
+		return nil
+	}
+
+	s := (*iterSlot)(atomic.LoadPointer(&registry[idx]))
+	if s == nil || s.gen != gen {
+		return nil
+	}
+	return s.it
+}
+
+// unregisterIter reclaims the handle and returns the iterator it pointed to (or nil if the handle
+// was invalid or already reclaimed), so the caller can cancel it outside the lock.
+func unregisterIter(handle int32) *iterator {
+	iterMu.Lock()
+	defer iterMu.Unlock()
+
+	idx, gen := unpackHandle(handle)
+	if idx >= len(iterRegistry) {
+		return nil
+	}
+	cur := (*iterSlot)(iterRegistry[idx])
+	if cur == nil || cur.gen != gen {
+		return nil
+	}
+
+	// Bump the generation, as Unregister in blockstore.go does, so a second unregisterIter call on
+	// this same handle fails the cur.gen != gen check above instead of re-freeing idx and
+	// corrupting the freelist into a self-loop.
+	atomic.StorePointer(&iterRegistry[idx], unsafe.Pointer(&iterSlot{gen: (gen + 1) & genMask}))
+
+	iterFreelist[idx] = iterLastIdx
+	iterLastIdx = idx
+
+	return cur.it
+}
+
+// cgobs_iter_open starts a traversal of every key in store and returns an iterator handle, or a
+// negative error code if store is invalid.
+//
+//export cgobs_iter_open
+func cgobs_iter_open(store C.int32_t) C.int32_t {
+	bs := Lookup(int32(store))
+	if bs == nil {
+		return ErrNoStore
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	keys, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		cancel()
+		return ErrIO
+	}
+
+	return registerIter(&iterator{keys: keys, cancel: cancel})
+}
+
+// cgobs_iter_next advances iter and writes the next CID into out_cid, a caller-allocated buffer of
+// at least out_cid_cap bytes. It returns 0 with out_cid_len set on success, ErrIterDone once the
+// traversal is exhausted, ErrBufferTooSmall (with the required size in out_cid_len) if the CID
+// doesn't fit, or ErrNoIter if the handle is invalid.
+//
+//export cgobs_iter_next
+func cgobs_iter_next(iter C.int32_t, out_cid C.mut_buf_t, out_cid_cap C.int32_t, out_cid_len *C.int32_t) C.int32_t {
+	it := lookupIter(int32(iter))
+	if it == nil {
+		return ErrNoIter
+	}
+
+	var c cid.Cid
+	if it.pending != nil {
+		c = *it.pending
+	} else {
+		var ok bool
+		c, ok = <-it.keys
+		if !ok {
+			return ErrIterDone
+		}
+	}
+
+	b := c.Bytes()
+	*out_cid_len = C.int32_t(len(b))
+	if len(b) > int(out_cid_cap) {
+		it.pending = &c
+		return ErrBufferTooSmall
+	}
+	it.pending = nil
+	copy(unsafe.Slice((*byte)(out_cid), out_cid_cap), b)
+	return 0
+}
+
+// cgobs_iter_close stops the traversal started by cgobs_iter_open, cancelling the context backing
+// it so the AllKeysChan goroutine actually exits rather than leaking until the channel drains.
+//
+//export cgobs_iter_close
+func cgobs_iter_close(iter C.int32_t) {
+	if it := unregisterIter(int32(iter)); it != nil {
+		it.cancel()
+	}
+}
@@ -0,0 +1,20 @@
+package extern
+
+import (
+	"testing"
+)
+
+func TestUnregisterIsIdempotent(t *testing.T) {
+	first := Register(&fakeExtern{})
+	Unregister(first)
+	Unregister(first) // the double-free: must be a harmless no-op.
+
+	second := Register(&fakeExtern{})
+	third := Register(&fakeExtern{})
+	if second == third {
+		t.Fatalf("Register handed out the same handle twice: %d", second)
+	}
+	if Lookup(second) == nil {
+		t.Fatalf("second handle %d was evicted by a later Register", second)
+	}
+}
@@ -1,17 +1,41 @@
 package extern
 
+/*
+#include <stdint.h>
+typedef const uint8_t* buf_t;
+*/
 import "C"
-import "unsafe"
+import (
+	"unsafe"
+
+	"github.com/filecoin-project/fvm/cgo/token"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+)
+
+// checkCancelled reports whether cancel's context has already been cancelled. vm.Extern's methods
+// take no context of their own, so we can't interrupt one mid-call; the best we can do is refuse
+// to start a (potentially expensive, e.g. consensus fault verification) call that was already
+// cancelled before it began.
+func checkCancelled(cancel C.int32_t) bool {
+	return token.Context(int32(cancel)).Err() != nil
+}
 
 //export cgo_extern_get_chain_randomness
-func cgo_extern_get_chain_randomness(handle C.int32_t, pers C.int64_t, round C.int64_t, entropy C.buf_t, entropy_len C.int32_t, randomness **C.uint8_t) C.int32_t {
+func cgo_extern_get_chain_randomness(handle C.int32_t, cancel C.int32_t, pers C.int64_t, round C.int64_t, entropy C.buf_t, entropy_len C.int32_t, randomness **C.uint8_t) C.int32_t {
 
 	ext := Lookup(int32(handle))
 	if ext == nil {
 		return ErrNoExtern
 	}
+	if checkCancelled(cancel) {
+		return ErrCancelled
+	}
 
-	r := ext.GetRandomnessFromTickets(C.int32_t(len(pers)), C.int32_t(len(round)), C.GoBytes(unsafe.Pointer(entropy), C.int(entropy_len)))
+	r, err := ext.GetRandomnessFromTickets(crypto.DomainSeparationTag(pers), abi.ChainEpoch(round), C.GoBytes(unsafe.Pointer(entropy), C.int(entropy_len)))
+	if err != nil {
+		return ErrIO
+	}
 
 	*randomness = (C.buf_t)(C.CBytes(r))
 
@@ -19,14 +43,20 @@ func cgo_extern_get_chain_randomness(handle C.int32_t, pers C.int64_t, round C.i
 }
 
 //export cgo_extern_get_beacon_randomness
-func cgo_extern_get_beacon_randomness(handle C.int32_t, pers C.int64_t, round C.int64_t, entropy C.buf_t, entropy_len C.int32_t, randomness **C.uint8_t) C.int32_t {
+func cgo_extern_get_beacon_randomness(handle C.int32_t, cancel C.int32_t, pers C.int64_t, round C.int64_t, entropy C.buf_t, entropy_len C.int32_t, randomness **C.uint8_t) C.int32_t {
 
 	ext := Lookup(int32(handle))
 	if ext == nil {
 		return ErrNoExtern
 	}
+	if checkCancelled(cancel) {
+		return ErrCancelled
+	}
 
-	r := ext.GetRandomnessFromBeacon(C.int32_t(len(pers)), C.int32_t(len(round)), C.GoBytes(unsafe.Pointer(entropy), C.int(entropy_len)))
+	r, err := ext.GetRandomnessFromBeacon(crypto.DomainSeparationTag(pers), abi.ChainEpoch(round), C.GoBytes(unsafe.Pointer(entropy), C.int(entropy_len)))
+	if err != nil {
+		return ErrIO
+	}
 
 	*randomness = (C.buf_t)(C.CBytes(r))
 
@@ -34,17 +64,32 @@ func cgo_extern_get_beacon_randomness(handle C.int32_t, pers C.int64_t, round C.
 }
 
 //export cgo_extern_verify_consensus_fault
-func cgo_extern_verify_consensus_fault(handle C.int32_t, h1 C.buf_t, h1_len C.int32_t, h2 C.buf_t, h2_len C.int32_t, extra C.buf_t, extra_len C.int32_t, target **C.uint8_t, target_size *C.int32_t, epoch *C.int64_t, fault_type *C.uint8_t) C.int32_t {
+func cgo_extern_verify_consensus_fault(handle C.int32_t, cancel C.int32_t, h1 C.buf_t, h1_len C.int32_t, h2 C.buf_t, h2_len C.int32_t, extra C.buf_t, extra_len C.int32_t, target **C.uint8_t, target_size *C.int32_t, epoch *C.int64_t, fault_type *C.uint8_t) C.int32_t {
 
 	ext := Lookup(int32(handle))
 	if ext == nil {
 		return ErrNoExtern
 	}
+	if checkCancelled(cancel) {
+		return ErrCancelled
+	}
+
+	targetAddress, faultEpoch, faultType, err := ext.VerifyConsensusFault(
+		C.GoBytes(unsafe.Pointer(h1), C.int(h1_len)),
+		C.GoBytes(unsafe.Pointer(h2), C.int(h2_len)),
+		C.GoBytes(unsafe.Pointer(extra), C.int(extra_len)),
+	)
+	if err != nil {
+		return ErrIO
+	}
 
-	targetAddress, faultEpoch, faultType := ext.VerifyConsensusFault(C.GoBytes(unsafe.Pointer(h1), C.int(h1_len)), C.GoBytes(unsafe.Pointer(h2), C.int(h2_len)), C.GoBytes(unsafe.Pointer(extra), C.int(extra_len)))
-	*target = (C.buf_t)(C.CBytes(targetAddress))
-	*target_size = C.int32_t(len(targetAddress.Bytes()))
-	*epoch = C.int32_t(faultEpoch)
+	// address.Address.Bytes() includes the leading protocol byte, which the Rust side needs to
+	// tell apart ID/SECP256K1/Actor/BLS addresses; the argument to GetRandomnessFrom{Tickets,Beacon}
+	// above is unrelated raw entropy and isn't an address, so no such marshalling applies there.
+	targetBytes := targetAddress.Bytes()
+	*target = (C.buf_t)(C.CBytes(targetBytes))
+	*target_size = C.int32_t(len(targetBytes))
+	*epoch = C.int64_t(faultEpoch)
 	*fault_type = C.uint8_t(faultType)
 
 	return 0
@@ -0,0 +1,121 @@
+package extern
+
+import (
+	"testing"
+	"unsafe"
+
+	address "github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/lotus/chain/vm"
+	"github.com/filecoin-project/specs-actors/actors/runtime"
+)
+
+/*
+#include <stdint.h>
+typedef const uint8_t* buf_t;
+*/
+import "C"
+
+// fakeExtern is a minimal vm.Extern used to exercise the FFI signatures above from Go, without
+// needing a real chain/beacon/consensus setup.
+type fakeExtern struct {
+	gotPers    crypto.DomainSeparationTag
+	gotRound   abi.ChainEpoch
+	gotEntropy []byte
+
+	randomness []byte
+
+	target     address.Address
+	faultEpoch abi.ChainEpoch
+	faultType  runtime.ConsensusFaultType
+}
+
+func (f *fakeExtern) GetRandomnessFromTickets(pers crypto.DomainSeparationTag, round abi.ChainEpoch, entropy []byte) ([]byte, error) {
+	f.gotPers, f.gotRound, f.gotEntropy = pers, round, entropy
+	return f.randomness, nil
+}
+
+func (f *fakeExtern) GetRandomnessFromBeacon(pers crypto.DomainSeparationTag, round abi.ChainEpoch, entropy []byte) ([]byte, error) {
+	f.gotPers, f.gotRound, f.gotEntropy = pers, round, entropy
+	return f.randomness, nil
+}
+
+func (f *fakeExtern) VerifyConsensusFault(h1, h2, extra []byte) (address.Address, abi.ChainEpoch, runtime.ConsensusFaultType, error) {
+	f.gotEntropy = extra
+	return f.target, f.faultEpoch, f.faultType, nil
+}
+
+func TestGetChainRandomnessRoundTrip(t *testing.T) {
+	fake := &fakeExtern{randomness: []byte("some randomness")}
+	handle := Register(fake)
+	defer Unregister(handle)
+
+	entropy := []byte("entropy bytes")
+	var out *C.uint8_t
+	status := cgo_extern_get_chain_randomness(
+		C.int32_t(handle), 0,
+		C.int64_t(crypto.DomainSeparationTagTicket), C.int64_t(1234),
+		(C.buf_t)(unsafe.Pointer(&entropy[0])), C.int32_t(len(entropy)),
+		&out,
+	)
+	if status != 0 {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	if fake.gotPers != crypto.DomainSeparationTagTicket {
+		t.Fatalf("personalization not unmarshalled correctly: got %v", fake.gotPers)
+	}
+	if fake.gotRound != 1234 {
+		t.Fatalf("round not unmarshalled correctly: got %v", fake.gotRound)
+	}
+	got := C.GoBytes(unsafe.Pointer(out), C.int(len(fake.randomness)))
+	if string(got) != string(fake.randomness) {
+		t.Fatalf("randomness round-tripped incorrectly: got %q", got)
+	}
+}
+
+func TestVerifyConsensusFaultRoundTrip(t *testing.T) {
+	target, err := address.NewIDAddress(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeExtern{
+		target:     target,
+		faultEpoch: 42,
+		faultType:  runtime.ConsensusFaultDoubleForkMining,
+	}
+	handle := Register(fake)
+	defer Unregister(handle)
+
+	h1, h2, extra := []byte("h1"), []byte("h2"), []byte("extra")
+	var out *C.uint8_t
+	var outSize C.int32_t
+	var outEpoch C.int64_t
+	var outType C.uint8_t
+	status := cgo_extern_verify_consensus_fault(
+		C.int32_t(handle), 0,
+		(C.buf_t)(unsafe.Pointer(&h1[0])), C.int32_t(len(h1)),
+		(C.buf_t)(unsafe.Pointer(&h2[0])), C.int32_t(len(h2)),
+		(C.buf_t)(unsafe.Pointer(&extra[0])), C.int32_t(len(extra)),
+		&out, &outSize, &outEpoch, &outType,
+	)
+	if status != 0 {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	wantBytes := target.Bytes()
+	if int(outSize) != len(wantBytes) {
+		t.Fatalf("target size mismatch: got %d want %d", outSize, len(wantBytes))
+	}
+	if got := C.GoBytes(unsafe.Pointer(out), outSize); string(got) != string(wantBytes) {
+		t.Fatalf("target address not marshalled with its protocol byte: got %x want %x", got, wantBytes)
+	}
+	if int64(outEpoch) != int64(fake.faultEpoch) {
+		t.Fatalf("epoch mismatch: got %d want %d", outEpoch, fake.faultEpoch)
+	}
+	if uint8(outType) != uint8(fake.faultType) {
+		t.Fatalf("fault type mismatch: got %d want %d", outType, fake.faultType)
+	}
+}
+
+var _ vm.Extern = (*fakeExtern)(nil)
@@ -0,0 +1,144 @@
+package extern
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/filecoin-project/fvm/cgo/histogram"
+	address "github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/lotus/chain/vm"
+	"github.com/filecoin-project/specs-actors/actors/runtime"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentedOptions configures an Instrumented runtime.
+type InstrumentedOptions struct {
+	// Tracer, if non-nil, causes every randomness/consensus-fault call to be wrapped in an
+	// OpenTelemetry span.
+	Tracer trace.Tracer
+}
+
+// CallStats holds the counters recorded for a single kind of call.
+type CallStats struct {
+	Calls, Errors          uint64
+	LatencyP50, LatencyP99 time.Duration
+}
+
+// Stats holds the counters recorded by an Instrumented runtime, as of the moment StatsOf was
+// called.
+type Stats struct {
+	Randomness     CallStats
+	ConsensusFault CallStats
+}
+
+// instrumentedExtern wraps a vm.Extern, recording per-handle counters (and optionally emitting
+// OpenTelemetry spans) around every call, mirroring cgo/blockstore's Instrumented.
+type instrumentedExtern struct {
+	inner vm.Extern
+	opts  InstrumentedOptions
+
+	randCalls, randErrs uint64
+	randLatency         histogram.Latency
+
+	cfCalls, cfErrs uint64
+	cfLatency       histogram.Latency
+}
+
+// Instrumented wraps inner so that call counts, error counts, and latency are recorded and
+// retrievable via StatsOf (or cgo_extern_stats, once registered with Register).
+func Instrumented(inner vm.Extern, opts InstrumentedOptions) vm.Extern {
+	return &instrumentedExtern{inner: inner, opts: opts}
+}
+
+func (e *instrumentedExtern) startSpan(name string, attrs ...attribute.KeyValue) trace.Span {
+	if e.opts.Tracer == nil {
+		return nil
+	}
+	// vm.Extern's methods take no context of their own, so spans here are always roots; gas
+	// exhaustion/deadline cancellation still flows through the cancel_token checked before these
+	// calls are ever made, in cgo/extern/ffi.go.
+	_, span := e.opts.Tracer.Start(context.Background(), name, trace.WithAttributes(attrs...))
+	return span
+}
+
+func endSpan(span trace.Span) {
+	if span != nil {
+		span.End()
+	}
+}
+
+func (e *instrumentedExtern) GetRandomnessFromTickets(pers crypto.DomainSeparationTag, round abi.ChainEpoch, entropy []byte) ([]byte, error) {
+	return e.timeRandomness("extern.GetRandomnessFromTickets", pers, round, entropy, e.inner.GetRandomnessFromTickets)
+}
+
+func (e *instrumentedExtern) GetRandomnessFromBeacon(pers crypto.DomainSeparationTag, round abi.ChainEpoch, entropy []byte) ([]byte, error) {
+	return e.timeRandomness("extern.GetRandomnessFromBeacon", pers, round, entropy, e.inner.GetRandomnessFromBeacon)
+}
+
+func (e *instrumentedExtern) timeRandomness(
+	spanName string,
+	pers crypto.DomainSeparationTag,
+	round abi.ChainEpoch,
+	entropy []byte,
+	call func(crypto.DomainSeparationTag, abi.ChainEpoch, []byte) ([]byte, error),
+) ([]byte, error) {
+	span := e.startSpan(spanName,
+		attribute.Int64("personalization", int64(pers)),
+		attribute.Int64("round", int64(round)),
+	)
+	defer endSpan(span)
+
+	start := time.Now()
+	r, err := call(pers, round, entropy)
+	e.randLatency.Record(time.Since(start))
+	atomic.AddUint64(&e.randCalls, 1)
+	if err != nil {
+		atomic.AddUint64(&e.randErrs, 1)
+	}
+	return r, err
+}
+
+func (e *instrumentedExtern) VerifyConsensusFault(h1, h2, extra []byte) (address.Address, abi.ChainEpoch, runtime.ConsensusFaultType, error) {
+	span := e.startSpan("extern.VerifyConsensusFault", attribute.Int("extra_size", len(extra)))
+	defer endSpan(span)
+
+	start := time.Now()
+	addr, epoch, faultType, err := e.inner.VerifyConsensusFault(h1, h2, extra)
+	e.cfLatency.Record(time.Since(start))
+	atomic.AddUint64(&e.cfCalls, 1)
+	if err != nil {
+		atomic.AddUint64(&e.cfErrs, 1)
+	}
+	return addr, epoch, faultType, err
+}
+
+func (e *instrumentedExtern) stats() Stats {
+	return Stats{
+		Randomness: CallStats{
+			Calls:      atomic.LoadUint64(&e.randCalls),
+			Errors:     atomic.LoadUint64(&e.randErrs),
+			LatencyP50: e.randLatency.Percentile(0.50),
+			LatencyP99: e.randLatency.Percentile(0.99),
+		},
+		ConsensusFault: CallStats{
+			Calls:      atomic.LoadUint64(&e.cfCalls),
+			Errors:     atomic.LoadUint64(&e.cfErrs),
+			LatencyP50: e.cfLatency.Percentile(0.50),
+			LatencyP99: e.cfLatency.Percentile(0.99),
+		},
+	}
+}
+
+// StatsOf returns the current counters for the runtime registered under handle, or the zero Stats
+// if handle doesn't refer to an Instrumented runtime.
+func StatsOf(handle int32) Stats {
+	inst, _ := Lookup(handle).(*instrumentedExtern)
+	if inst == nil {
+		return Stats{}
+	}
+	return inst.stats()
+}
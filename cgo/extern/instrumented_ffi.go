@@ -0,0 +1,38 @@
+package extern
+
+/*
+#include <stdint.h>
+typedef struct {
+	uint64_t randomness_calls;
+	uint64_t randomness_errors;
+	int64_t randomness_p50_ns;
+	int64_t randomness_p99_ns;
+	uint64_t consensus_fault_calls;
+	uint64_t consensus_fault_errors;
+	int64_t consensus_fault_p50_ns;
+	int64_t consensus_fault_p99_ns;
+} cgo_extern_stats_t;
+*/
+import "C"
+
+// cgo_extern_stats fills out with the counters recorded for handle, which must have been wrapped
+// with Instrumented before being registered. Returns ErrNotInstrumented if it wasn't.
+//
+//export cgo_extern_stats
+func cgo_extern_stats(handle C.int32_t, out *C.cgo_extern_stats_t) C.int32_t {
+	inst, _ := Lookup(int32(handle)).(*instrumentedExtern)
+	if inst == nil {
+		return ErrNotInstrumented
+	}
+
+	s := inst.stats()
+	out.randomness_calls = C.uint64_t(s.Randomness.Calls)
+	out.randomness_errors = C.uint64_t(s.Randomness.Errors)
+	out.randomness_p50_ns = C.int64_t(s.Randomness.LatencyP50.Nanoseconds())
+	out.randomness_p99_ns = C.int64_t(s.Randomness.LatencyP99.Nanoseconds())
+	out.consensus_fault_calls = C.uint64_t(s.ConsensusFault.Calls)
+	out.consensus_fault_errors = C.uint64_t(s.ConsensusFault.Errors)
+	out.consensus_fault_p50_ns = C.int64_t(s.ConsensusFault.LatencyP50.Nanoseconds())
+	out.consensus_fault_p99_ns = C.int64_t(s.ConsensusFault.LatencyP99.Nanoseconds())
+	return 0
+}
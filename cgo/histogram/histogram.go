@@ -0,0 +1,61 @@
+// Package histogram provides a small latency histogram shared by cgo/blockstore and cgo/extern's
+// Instrumented wrappers, so the two don't carry their own copies of the same bucketing logic.
+package histogram
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// Latency is a small HDR-style (exponentially-spaced buckets, so both microsecond and
+// multi-second latencies are represented with reasonable relative precision) histogram of call
+// latencies. Recording a sample and reading a percentile are both lock-free.
+type Latency struct {
+	buckets [64]uint64 // buckets[i] counts samples of duration in [2^i, 2^(i+1)) ns.
+}
+
+// Record adds a sample to the histogram.
+func (h *Latency) Record(d time.Duration) {
+	n := d.Nanoseconds()
+	if n < 1 {
+		n = 1
+	}
+	bucket := bits.Len64(uint64(n)) - 1
+	if bucket >= len(h.buckets) {
+		bucket = len(h.buckets) - 1
+	}
+	atomic.AddUint64(&h.buckets[bucket], 1)
+}
+
+// Percentile returns the approximate duration below which the given fraction (e.g. 0.5 or 0.99) of
+// recorded samples fall, rounded up to the bucket's upper bound.
+func (h *Latency) Percentile(p float64) time.Duration {
+	var snapshot [64]uint64
+	var total uint64
+	for i := range h.buckets {
+		snapshot[i] = atomic.LoadUint64(&h.buckets[i])
+		total += snapshot[i]
+	}
+	if total == 0 {
+		return 0
+	}
+
+	target := uint64(math.Ceil(p * float64(total)))
+	var cum uint64
+	for i, c := range snapshot {
+		cum += c
+		if cum >= target {
+			// The upper bounds of buckets 62 and 63 (2^63 and 2^64 ns) don't fit in a time.Duration.
+			if i+1 >= 63 {
+				return time.Duration(math.MaxInt64)
+			}
+			return time.Duration(uint64(1) << uint(i+1))
+		}
+	}
+	// Unreached in practice, since bucket 63 absorbs every sample Record clamps into it, so cum
+	// always reaches target by the last iteration above. Fall back to the largest representable
+	// duration, as the loop does for the top buckets.
+	return time.Duration(math.MaxInt64)
+}
@@ -0,0 +1,74 @@
+// Package token provides a small registry of cancellation tokens shared across the cgo FFI
+// boundary. A Rust-side caller creates a token once with New, passes its handle as the
+// cancel_token argument to any number of blockstore/extern calls, and calls Cancel when it wants
+// every call sharing that token to observe ctx.Done() — for example because the message execution
+// those calls were made on behalf of was aborted for gas exhaustion or hit a deadline.
+package token
+
+import (
+	"context"
+	"sync"
+)
+
+var (
+	mu      sync.Mutex
+	ctxs    = map[int32]context.Context{}
+	cancels = map[int32]context.CancelFunc{}
+	next    int32
+)
+
+// New creates a new cancellation token and returns its handle.
+func New() int32 {
+	mu.Lock()
+	defer mu.Unlock()
+
+	next++
+	handle := next
+	ctx, cancel := context.WithCancel(context.Background())
+	ctxs[handle] = ctx
+	cancels[handle] = cancel
+	return handle
+}
+
+// cancelledCtx is returned by Context for a token that New issued and Cancel has since forgotten.
+var cancelledCtx = func() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}()
+
+// Context returns the context associated with handle. Handle zero is reserved to mean "no token",
+// and a handle New never issued is treated the same way, so both return context.Background(). A
+// handle that has already been cancelled returns an already-cancelled context.
+func Context(handle int32) context.Context {
+	if handle == 0 {
+		return context.Background()
+	}
+
+	mu.Lock()
+	ctx, ok := ctxs[handle]
+	issued := handle > 0 && handle <= next
+	mu.Unlock()
+
+	if ok {
+		return ctx
+	}
+	if issued {
+		return cancelledCtx
+	}
+	return context.Background()
+}
+
+// Cancel cancels the context associated with handle and forgets it. It is a no-op for an unknown
+// or already-cancelled handle, so it's safe to call more than once.
+func Cancel(handle int32) {
+	mu.Lock()
+	cancel, ok := cancels[handle]
+	delete(ctxs, handle)
+	delete(cancels, handle)
+	mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+}